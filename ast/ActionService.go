@@ -0,0 +1,96 @@
+package ast
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// ActionService wraps a bare Go function so it can be invoked by name from rule scripts
+// without a receiver, eg. `SendEmail(User.Email, "hi")`.
+type ActionService struct {
+	Name     string
+	Function interface{}
+	Type     reflect.Type
+}
+
+// newActionService builds an ActionService out of a bare function or an existing one.
+func newActionService(name string, fn interface{}) (*ActionService, error) {
+	if svc, ok := fn.(ActionService); ok {
+		fn = svc.Function
+	} else if svc, ok := fn.(*ActionService); ok {
+		fn = svc.Function
+	}
+	fnVal := reflect.ValueOf(fn)
+	if fnVal.Kind() != reflect.Func {
+		return nil, fmt.Errorf("you can only register a function as a service. got = %s", fnVal.Kind().String())
+	}
+	return &ActionService{
+		Name:     name,
+		Function: fn,
+		Type:     fnVal.Type(),
+	}, nil
+}
+
+// invoke calls the underlying function, accepting variadic signatures and functions that
+// return a value, an error, or a (value, error) pair. ctx is injected automatically when the
+// function's first parameter is a context.Context.
+func (svc *ActionService) invoke(args []reflect.Value, ctx context.Context) (reflect.Value, error) {
+	fnVal := reflect.ValueOf(svc.Function)
+	fnType := fnVal.Type()
+	numIn := fnType.NumIn()
+	variadic := fnType.IsVariadic()
+
+	ctxOffset := 0
+	if numIn > 0 && fnType.In(0) == contextType {
+		ctxOffset = 1
+	}
+	numParams := numIn - ctxOffset
+
+	if !variadic && len(args) != numParams {
+		return reflect.ValueOf(nil),
+			fmt.Errorf("invalid argument count for service %s(). need %d argument while there are %d", svc.Name, numParams, len(args))
+	}
+	if variadic && len(args) < numParams-1 {
+		return reflect.ValueOf(nil),
+			fmt.Errorf("invalid argument count for service %s(). need at least %d argument while there are %d", svc.Name, numParams-1, len(args))
+	}
+
+	callArgs := make([]reflect.Value, ctxOffset+len(args))
+	if ctxOffset == 1 {
+		callArgs[0] = reflect.ValueOf(ctx)
+	}
+	for i, arg := range args {
+		var paramType reflect.Type
+		if variadic && i >= numParams-1 {
+			paramType = fnType.In(numIn - 1).Elem()
+		} else {
+			paramType = fnType.In(ctxOffset + i)
+		}
+		if arg.Type().AssignableTo(paramType) {
+			callArgs[ctxOffset+i] = arg
+		} else if arg.Type().ConvertibleTo(paramType) {
+			callArgs[ctxOffset+i] = arg.Convert(paramType)
+		} else {
+			return reflect.ValueOf(nil),
+				fmt.Errorf("invalid argument type for service %s(). argument #%d, require %s but %s", svc.Name, i, paramType.String(), arg.Type().String())
+		}
+	}
+
+	rets := fnVal.Call(callArgs)
+	switch retLen := len(rets); {
+	case retLen == 0:
+		return reflect.ValueOf(nil), nil
+	case retLen == 1:
+		if err, ok := rets[0].Interface().(error); ok {
+			return reflect.ValueOf(nil), err
+		}
+		return rets[0], nil
+	default:
+		last := rets[retLen-1]
+		if err, ok := last.Interface().(error); ok && !last.IsNil() {
+			return rets[0], err
+		}
+		return rets[0], nil
+	}
+}
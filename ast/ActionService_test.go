@@ -0,0 +1,70 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDataContext_AddService_Variadic(t *testing.T) {
+	dataContext := NewDataContext()
+	sum := func(nums ...int) int {
+		total := 0
+		for _, n := range nums {
+			total += n
+		}
+		return total
+	}
+	if err := dataContext.AddService("Sum", sum); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []reflect.Value{reflect.ValueOf(1), reflect.ValueOf(2), reflect.ValueOf(3)}
+	ret, err := dataContext.ExecMethod("Sum", args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Interface().(int) != 6 {
+		t.Fatalf("expected 6 but got %v", ret.Interface())
+	}
+}
+
+func TestDataContext_AddService_MultiReturn(t *testing.T) {
+	dataContext := NewDataContext()
+	divide := func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	}
+	if err := dataContext.AddService("Divide", divide); err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := dataContext.ExecMethod("Divide", []reflect.Value{reflect.ValueOf(10), reflect.ValueOf(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Interface().(int) != 5 {
+		t.Fatalf("expected 5 but got %v", ret.Interface())
+	}
+
+	_, err = dataContext.ExecMethod("Divide", []reflect.Value{reflect.ValueOf(10), reflect.ValueOf(0)})
+	if err == nil {
+		t.Fatal("expected an error dividing by zero")
+	}
+}
+
+func TestDataContext_AddService_Retracted(t *testing.T) {
+	dataContext := NewDataContext()
+	greet := func() string { return "hi" }
+	if err := dataContext.AddService("Greet", greet); err != nil {
+		t.Fatal(err)
+	}
+
+	dataContext.Retract("Greet")
+	if _, err := dataContext.ExecMethod("Greet", []reflect.Value{}); err == nil {
+		t.Fatal("expected an error calling a retracted service")
+	}
+}
+
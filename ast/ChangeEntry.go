@@ -0,0 +1,13 @@
+package ast
+
+import "time"
+
+// ChangeEntry records a single mutation made through DataContext.SetValue: the path that was
+// written, its value before and after the write, which rule caused it, and when it happened.
+type ChangeEntry struct {
+	Path     string
+	Old      interface{}
+	New      interface{}
+	RuleName string
+	At       time.Time
+}
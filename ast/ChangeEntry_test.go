@@ -0,0 +1,42 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+type counterFact struct {
+	Value int
+}
+
+func TestDataContext_Changes_RecordsRuleProvenance(t *testing.T) {
+	dataContext := NewDataContext()
+	counter := &counterFact{Value: 0}
+	if err := dataContext.Add("Counter", counter); err != nil {
+		t.Fatal(err)
+	}
+
+	dataContext.SetCurrentRule("IncrementByOne")
+	if err := dataContext.SetValue("Counter.Value", reflect.ValueOf(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	dataContext.SetCurrentRule("DoubleIt")
+	if err := dataContext.SetValue("Counter.Value", reflect.ValueOf(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := dataContext.Changes()
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 change entries but got %d", len(changes))
+	}
+	if changes[0].RuleName != "IncrementByOne" {
+		t.Fatalf("expected first change to be attributed to IncrementByOne but got %q", changes[0].RuleName)
+	}
+	if changes[1].RuleName != "DoubleIt" {
+		t.Fatalf("expected second change to be attributed to DoubleIt but got %q", changes[1].RuleName)
+	}
+	if changes[0].RuleName == changes[1].RuleName {
+		t.Fatal("expected the two rules to produce distinct RuleName provenance")
+	}
+}
@@ -3,20 +3,30 @@ package ast
 //go:generate mockgen -destination=../mocks/ast/DataContext.go -package=mocksAst . IDataContext
 
 import (
+	"context"
 	"fmt"
 	"reflect"
-	"strings"
+	"time"
 
 	"github.com/hyperjumptech/grule-rule-engine/pkg"
 )
 
+// contextType is the reflect.Type of the context.Context interface, used to detect
+// functions whose first parameter should be fed the DataContext's stored context
+// automatically instead of being supplied by the rule script.
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 // NewDataContext will create a new DataContext instance
 func NewDataContext() IDataContext {
 	return &DataContext{
 		ObjectStore: make(map[string]interface{}),
 
-		retracted:           make([]string, 0),
+		retracted:           make(map[string]struct{}),
+		retractCycles:       make(map[string]int),
+		retractPending:      make(map[string]struct{}),
 		variableChangeCount: 0,
+		ctx:                 context.Background(),
+		changes:             make([]ChangeEntry, 0),
 	}
 }
 
@@ -24,9 +34,39 @@ func NewDataContext() IDataContext {
 type DataContext struct {
 	ObjectStore map[string]interface{}
 
-	retracted           []string
+	retracted           map[string]struct{}
+	retractCycles       map[string]int
+	retractPending      map[string]struct{}
 	variableChangeCount uint64
 	complete            bool
+	ctx                 context.Context
+
+	changes     []ChangeEntry
+	currentRule string
+}
+
+// WithContext attaches ctx to this DataContext so it can be observed by GruleEngine.Execute
+// (to abort a cycle on cancellation or deadline) and by functions invoked through ExecMethod
+// that accept a context.Context as their first parameter.
+func (ctx *DataContext) WithContext(c context.Context) IDataContext {
+	ctx.ctx = c
+	return ctx
+}
+
+// Context returns the context.Context currently attached to this DataContext, defaulting to
+// context.Background() when none was set via WithContext.
+func (ctx *DataContext) Context() context.Context {
+	if ctx.ctx == nil {
+		return context.Background()
+	}
+	return ctx.ctx
+}
+
+// Value is a convenience accessor for ctx.Context().Value(key), letting rule-invoked functions
+// reach request-scoped state (tenant id, trace id, logger, ...) without threading the context
+// through every call explicitly.
+func (ctx *DataContext) Value(key interface{}) interface{} {
+	return ctx.Context().Value(key)
 }
 
 // Complete marks the DataContext as completed, telling the engine to stop processing rules
@@ -46,14 +86,28 @@ type IDataContext interface {
 	HasVariableChange() bool
 
 	Add(key string, obj interface{}) error
+	AddService(name string, fn interface{}) error
+
+	WithContext(ctx context.Context) IDataContext
+	Context() context.Context
+	Value(key interface{}) interface{}
 
 	Retract(key string)
+	RetractFor(key string, cycles int)
 	IsRetracted(key string) bool
 	Complete()
 	IsComplete() bool
 	Retracted() []string
 	Reset()
 
+	Snapshot() RetractionSnapshot
+	Restore(snap RetractionSnapshot)
+
+	SetCurrentRule(ruleName string)
+	Changes() []ChangeEntry
+	ChangesSince(n int) []ChangeEntry
+	ClearChanges()
+
 	ExecMethod(methodName string, args []reflect.Value) (reflect.Value, error)
 
 	GetType(variable string) (reflect.Type, error)
@@ -90,62 +144,193 @@ func (ctx *DataContext) Add(key string, obj interface{}) error {
 	return nil
 }
 
+// AddService will register a bare Go function, or a named service wrapping one, into the
+// rule execution context under name. Registered services are invoked directly by name from
+// rule scripts, eg. `SendEmail(User.Email, "hi")`, without needing a receiver instance.
+func (ctx *DataContext) AddService(name string, fn interface{}) error {
+	svc, err := newActionService(name, fn)
+	if err != nil {
+		return err
+	}
+	ctx.ObjectStore[name] = svc
+	return nil
+}
+
 // Retract temporary retract a fact from data context, making it unavailable for evaluation or modification.
 func (ctx *DataContext) Retract(key string) {
-	ctx.retracted = append(ctx.retracted, key)
+	ctx.retracted[key] = struct{}{}
+}
+
+// RetractFor retracts key the same way Retract does, but automatically un-retracts it again
+// after `cycles` full engine cycles instead of staying retracted until Reset is called. The
+// cycle already in progress when RetractFor is called does not count towards that total; see
+// Snapshot for how the countdown is aged.
+func (ctx *DataContext) RetractFor(key string, cycles int) {
+	ctx.retracted[key] = struct{}{}
+	ctx.retractCycles[key] = cycles
+	ctx.retractPending[key] = struct{}{}
 }
 
 // IsRetracted checks if a key fact is currently retracted.
 func (ctx *DataContext) IsRetracted(key string) bool {
-	for _, v := range ctx.retracted {
-		if v == key {
-			return true
-		}
-	}
-	return false
+	_, ok := ctx.retracted[key]
+	return ok
 }
 
 // Retracted returns list of retracted key facts.
 func (ctx *DataContext) Retracted() []string {
-	return ctx.retracted
+	keys := make([]string, 0, len(ctx.retracted))
+	for k := range ctx.retracted {
+		keys = append(keys, k)
+	}
+	return keys
 }
 
 // Reset will un-retract all fact, making them available for evaluation and modification.
 func (ctx *DataContext) Reset() {
-	ctx.retracted = make([]string, 0)
+	ctx.retracted = make(map[string]struct{})
+	ctx.retractCycles = make(map[string]int)
+	ctx.retractPending = make(map[string]struct{})
+}
+
+// RetractionSnapshot is an opaque checkpoint of the retracted-fact set, taken via
+// DataContext.Snapshot and restored via DataContext.Restore if a cycle aborts partway through.
+type RetractionSnapshot struct {
+	retracted map[string]struct{}
+	cycles    map[string]int
+}
+
+// Snapshot checkpoints the current retracted set, ages down any RetractFor countdowns by one
+// cycle, and returns the resulting state so the engine can roll back to it with Restore if the
+// cycle it is about to run fails. A key only starts aging down on the Snapshot call after the
+// one that follows its RetractFor call, so RetractFor(key, cycles) keeps key retracted through
+// `cycles` full subsequent cycles before Snapshot finally un-retracts it, rather than counting
+// the in-progress cycle towards the total.
+func (ctx *DataContext) Snapshot() RetractionSnapshot {
+	for key, remaining := range ctx.retractCycles {
+		if _, pending := ctx.retractPending[key]; pending {
+			delete(ctx.retractPending, key)
+			continue
+		}
+		remaining--
+		if remaining <= 0 {
+			delete(ctx.retractCycles, key)
+			delete(ctx.retracted, key)
+		} else {
+			ctx.retractCycles[key] = remaining
+		}
+	}
+
+	retracted := make(map[string]struct{}, len(ctx.retracted))
+	for k := range ctx.retracted {
+		retracted[k] = struct{}{}
+	}
+	cycles := make(map[string]int, len(ctx.retractCycles))
+	for k, v := range ctx.retractCycles {
+		cycles[k] = v
+	}
+	return RetractionSnapshot{retracted: retracted, cycles: cycles}
+}
+
+// Restore rolls the retracted set back to a previously taken Snapshot, eg. when an engine cycle
+// aborts partway through and any retractions it made should not stick.
+func (ctx *DataContext) Restore(snap RetractionSnapshot) {
+	ctx.retracted = make(map[string]struct{}, len(snap.retracted))
+	for k := range snap.retracted {
+		ctx.retracted[k] = struct{}{}
+	}
+	ctx.retractCycles = make(map[string]int, len(snap.cycles))
+	for k, v := range snap.cycles {
+		ctx.retractCycles[k] = v
+	}
+	ctx.retractPending = make(map[string]struct{})
+}
+
+// SetCurrentRule marks ruleName as the rule currently firing, so any change it makes through
+// SetValue is recorded in the change journal with that provenance. The engine calls this
+// before evaluating each rule's `then` block.
+func (ctx *DataContext) SetCurrentRule(ruleName string) {
+	ctx.currentRule = ruleName
+}
+
+// Changes returns the full change journal recorded so far through SetValue, in the order the
+// mutations happened.
+func (ctx *DataContext) Changes() []ChangeEntry {
+	return ctx.changes
+}
+
+// ChangesSince returns the change journal entries from index n onwards, ie. ctx.changes[n:].
+// Callers typically remember len(ctx.Changes()) between cycles and pass it back in here to see
+// what changed since.
+func (ctx *DataContext) ChangesSince(n int) []ChangeEntry {
+	if n < 0 || n >= len(ctx.changes) {
+		return []ChangeEntry{}
+	}
+	return ctx.changes[n:]
+}
+
+// ClearChanges empties the change journal.
+func (ctx *DataContext) ClearChanges() {
+	ctx.changes = make([]ChangeEntry, 0)
 }
 
 // ExecMethod will execute instance member variable using the supplied arguments.
 func (ctx *DataContext) ExecMethod(methodName string, args []reflect.Value) (reflect.Value, error) {
-	varArray := strings.Split(methodName, ".")
-	if val, ok := ctx.ObjectStore[varArray[0]]; ok {
-		if !ctx.IsRetracted(varArray[0]) {
-			return traceMethod(val, varArray[1:], args)
+	path, err := tokenizePath(methodName)
+	if err != nil {
+		return reflect.ValueOf(nil), err
+	}
+	root, err := rootKey(path)
+	if err != nil {
+		return reflect.ValueOf(nil), err
+	}
+	if val, ok := ctx.ObjectStore[root]; ok {
+		if !ctx.IsRetracted(root) {
+			if len(path) == 1 {
+				if svc, ok := val.(*ActionService); ok {
+					return svc.invoke(args, ctx.Context())
+				}
+			}
+			return traceMethod(val, path[1:], args, ctx.Context())
 		}
 		return reflect.ValueOf(nil), fmt.Errorf("fact is retracted")
 	}
-	return reflect.ValueOf(nil), fmt.Errorf("fact [%s] not found while execute method", varArray[0])
+	return reflect.ValueOf(nil), fmt.Errorf("fact [%s] not found while execute method", root)
 }
 
 // GetType will extract type information of data in this context.
 func (ctx *DataContext) GetType(variable string) (reflect.Type, error) {
-	varArray := strings.Split(variable, ".")
-	if val, ok := ctx.ObjectStore[varArray[0]]; ok {
-		if !ctx.IsRetracted(varArray[0]) {
-			return traceType(val, varArray[1:])
+	path, err := tokenizePath(variable)
+	if err != nil {
+		return nil, err
+	}
+	root, err := rootKey(path)
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := ctx.ObjectStore[root]; ok {
+		if !ctx.IsRetracted(root) {
+			return traceType(val, path[1:])
 		}
 		return nil, fmt.Errorf("fact is retracted")
 	}
-	return nil, fmt.Errorf("fact [%s] not found while obtaining type", variable)
+	return nil, fmt.Errorf("fact [%s] not found while obtaining type", root)
 }
 
 // GetValue will get member variables Value information.
 // Used by the rule execution to obtain variable value.
 func (ctx *DataContext) GetValue(variable string) (reflect.Value, error) {
-	varArray := strings.Split(variable, ".")
-	if val, ok := ctx.ObjectStore[varArray[0]]; ok {
-		if !ctx.IsRetracted(varArray[0]) {
-			vval, err := traceValue(val, varArray[1:])
+	path, err := tokenizePath(variable)
+	if err != nil {
+		return reflect.ValueOf(nil), err
+	}
+	root, err := rootKey(path)
+	if err != nil {
+		return reflect.ValueOf(nil), err
+	}
+	if val, ok := ctx.ObjectStore[root]; ok {
+		if !ctx.IsRetracted(root) {
+			vval, err := traceValue(val, path[1:])
 			if err != nil {
 				fmt.Printf("blah %s = %v\n", variable, vval)
 			}
@@ -153,38 +338,66 @@ func (ctx *DataContext) GetValue(variable string) (reflect.Value, error) {
 		}
 		return reflect.ValueOf(nil), fmt.Errorf("fact is retracted")
 	}
-	return reflect.ValueOf(nil), fmt.Errorf("fact [%s] not found while retrieving value", varArray[0])
+	return reflect.ValueOf(nil), fmt.Errorf("fact [%s] not found while retrieving value", root)
 }
 
 // SetValue will set variable value of an object instance in this data context, Used by rule script to set values.
 func (ctx *DataContext) SetValue(variable string, newValue reflect.Value) error {
-	varArray := strings.Split(variable, ".")
-	if val, ok := ctx.ObjectStore[varArray[0]]; ok {
-		if !ctx.IsRetracted(varArray[0]) {
-			err := traceSetValue(val, varArray[1:], newValue)
+	path, err := tokenizePath(variable)
+	if err != nil {
+		return err
+	}
+	root, err := rootKey(path)
+	if err != nil {
+		return err
+	}
+	if val, ok := ctx.ObjectStore[root]; ok {
+		if !ctx.IsRetracted(root) {
+			var oldInterface interface{}
+			if oldValue, snapErr := traceValue(val, path[1:]); snapErr == nil && oldValue.IsValid() {
+				oldInterface = pkg.ValueToInterface(oldValue)
+			}
+			err := traceSetValue(val, path[1:], newValue)
 			if err == nil {
 				ctx.variableChangeCount++
+				ctx.changes = append(ctx.changes, ChangeEntry{
+					Path:     variable,
+					Old:      oldInterface,
+					New:      pkg.ValueToInterface(newValue),
+					RuleName: ctx.currentRule,
+					At:       time.Now(),
+				})
 			}
 			return err
 		}
 		return fmt.Errorf("fact is retracted")
 	}
-	return fmt.Errorf("fact [%s] not found while setting value", varArray[0])
+	return fmt.Errorf("fact [%s] not found while setting value", root)
 }
 
 func (ctx *DataContext) ResetAllFiledZero() {
 	ctx.complete = false
 	ctx.ObjectStore = make(map[string]interface{})
-	ctx.retracted = make([]string, 0)
+	ctx.retracted = make(map[string]struct{})
+	ctx.retractCycles = make(map[string]int)
+	ctx.retractPending = make(map[string]struct{})
 	ctx.variableChangeCount = 0
+	ctx.changes = make([]ChangeEntry, 0)
+	ctx.currentRule = ""
 }
 
-func traceType(obj interface{}, path []string) (reflect.Type, error) {
+func traceType(obj interface{}, path []pathSegment) (reflect.Type, error) {
 	switch length := len(path); {
+	case length == 1 && path[0].kind == segmentField:
+		return pkg.GetAttributeType(obj, path[0].field)
 	case length == 1:
-		return pkg.GetAttributeType(obj, path[0])
+		val, err := collectionValue(obj, path[0])
+		if err != nil {
+			return nil, err
+		}
+		return val.Type(), nil
 	case length > 1:
-		objVal, err := pkg.GetAttributeValue(obj, path[0])
+		objVal, err := stepValue(obj, path[0])
 		if err != nil {
 			return nil, err
 		}
@@ -194,12 +407,12 @@ func traceType(obj interface{}, path []string) (reflect.Type, error) {
 	}
 }
 
-func traceValue(obj interface{}, path []string) (reflect.Value, error) {
+func traceValue(obj interface{}, path []pathSegment) (reflect.Value, error) {
 	switch length := len(path); {
 	case length == 1:
-		return pkg.GetAttributeValue(obj, path[0])
+		return stepValue(obj, path[0])
 	case length > 1:
-		objVal, err := pkg.GetAttributeValue(obj, path[0])
+		objVal, err := stepValue(obj, path[0])
 		if err != nil {
 			return objVal, err
 		}
@@ -209,80 +422,116 @@ func traceValue(obj interface{}, path []string) (reflect.Value, error) {
 	}
 }
 
-func traceSetValue(obj interface{}, path []string, newValue reflect.Value) error {
+// stepValue resolves a single path segment against obj, dispatching to pkg.GetAttributeValue
+// for struct field access or to collectionValue for map/slice/array indexing.
+func stepValue(obj interface{}, seg pathSegment) (reflect.Value, error) {
+	if seg.kind == segmentField {
+		return pkg.GetAttributeValue(obj, seg.field)
+	}
+	return collectionValue(obj, seg)
+}
+
+func traceSetValue(obj interface{}, path []pathSegment, newValue reflect.Value) error {
 	switch length := len(path); {
 	case length == 1:
-		return pkg.SetAttributeValue(obj, path[0], newValue)
+		if path[0].kind == segmentField {
+			return pkg.SetAttributeValue(obj, path[0].field, newValue)
+		}
+		return setCollectionValue(obj, path[0], newValue)
+	case length > 1 && path[0].kind == segmentKey:
+		// a map value obtained via MapIndex is never addressable, even once a deeper field on it
+		// is reached, so writing through it has to rebuild the entry: copy it out, set the rest
+		// of the path on the copy, then write the copy back with SetMapIndex.
+		return traceSetMapEntry(obj, path[0], path[1:], newValue)
 	case length > 1:
-		objVal, err := pkg.GetAttributeValue(obj, path[0])
+		objVal, err := stepValue(obj, path[0])
 		if err != nil {
 			return err
 		}
+		// objVal is passed through as reflect.Value, not pkg.ValueToInterface(objVal): unlike
+		// traceValue/traceType, this path writes through obj, and calling .Interface() on a
+		// reflect.Value obtained from a non-pointer struct field detaches it from the original,
+		// losing addressability for the rest of the recursion.
 		return traceSetValue(objVal, path[1:], newValue)
 	default:
 		return fmt.Errorf("no attribute path specified")
 	}
 }
 
-func traceMethod(obj interface{}, path []string, args []reflect.Value) (reflect.Value, error) {
+func traceMethod(obj interface{}, path []pathSegment, args []reflect.Value, ctx context.Context) (reflect.Value, error) {
 
 	switch length := len(path); {
-	case length == 1:
+	case length == 1 && path[0].kind == segmentField:
+		methodName := path[0].field
 		// this obj is reflect.Value... it should not.
-		types, variad, err := pkg.GetFunctionParameterTypes(obj, path[0])
+		types, variad, err := pkg.GetFunctionParameterTypes(obj, methodName)
 		if err != nil {
 			return reflect.ValueOf(nil),
-				fmt.Errorf("error while fetching function %s() parameter types. Got %v", path[0], err)
+				fmt.Errorf("error while fetching function %s() parameter types. Got %v", methodName, err)
+		}
+
+		// a function whose first parameter is a context.Context receives the DataContext's
+		// stored context automatically; it is not supplied by the rule script.
+		ctxOffset := 0
+		if len(types) > 0 && types[0] == contextType {
+			ctxOffset = 1
 		}
+		paramTypes := types[ctxOffset:]
 
-		if len(types) != len(args) && !variad {
+		if len(paramTypes) != len(args) && !variad {
 			return reflect.ValueOf(nil),
-				fmt.Errorf("invalid argument count for function %s(). need %d argument while there are %d", path[0], len(types), len(args))
+				fmt.Errorf("invalid argument count for function %s(). need %d argument while there are %d", methodName, len(paramTypes), len(args))
+		}
+		iargs := make([]interface{}, ctxOffset+len(args))
+		if ctxOffset == 1 {
+			iargs[0] = ctx
 		}
-		iargs := make([]interface{}, len(args))
-		for i, t := range types {
-			if variad && i == len(types)-1 {
+		for i, t := range paramTypes {
+			if variad && i == len(paramTypes)-1 {
 				break
 			}
 			if t.Kind() != args[i].Kind() {
 				if t.Kind() == reflect.Interface {
-					iargs[i] = pkg.ValueToInterface(args[i])
+					iargs[ctxOffset+i] = pkg.ValueToInterface(args[i])
 				} else {
 					return reflect.ValueOf(nil),
-						fmt.Errorf("invalid argument types for function %s(). argument #%d, require %s but %s", path[0], i, t.Kind().String(), args[i].Kind().String())
+						fmt.Errorf("invalid argument types for function %s(). argument #%d, require %s but %s", methodName, i, t.Kind().String(), args[i].Kind().String())
 				}
 			} else {
-				iargs[i] = pkg.ValueToInterface(args[i])
+				iargs[ctxOffset+i] = pkg.ValueToInterface(args[i])
 			}
 		}
 		if variad {
-			typ := types[len(types)-1].Elem().Kind()
-			for i := len(types) - 1; i < len(args); i++ {
+			typ := paramTypes[len(paramTypes)-1].Elem().Kind()
+			for i := len(paramTypes) - 1; i < len(args); i++ {
 				if args[i].Kind() != typ {
 					return reflect.ValueOf(nil),
-						fmt.Errorf("invalid variadic argument types for function %s(). argument #%d, require %s but %s", path[0], i, typ.String(), args[i].Kind().String())
+						fmt.Errorf("invalid variadic argument types for function %s(). argument #%d, require %s but %s", methodName, i, typ.String(), args[i].Kind().String())
 				}
-				iargs[i] = pkg.ValueToInterface(args[i])
+				iargs[ctxOffset+i] = pkg.ValueToInterface(args[i])
 			}
 		}
-		rets, err := pkg.InvokeFunction(obj, path[0], iargs)
+		rets, err := pkg.InvokeFunction(obj, methodName, iargs)
 		if err != nil {
 			return reflect.ValueOf(nil), err
 		}
 		switch retLen := len(rets); {
 		case retLen > 1:
-			return reflect.ValueOf(rets[0]), fmt.Errorf("multiple return value for function %s(). ", path[0])
+			return reflect.ValueOf(rets[0]), fmt.Errorf("multiple return value for function %s(). ", methodName)
 		case retLen == 1:
 			return reflect.ValueOf(rets[0]), nil
 		default:
 			return reflect.ValueOf(nil), nil
 		}
 	case length > 1:
-		objVal, err := pkg.GetAttributeValue(obj, path[0])
+		objVal, err := stepValue(obj, path[0])
 		if err != nil {
 			return reflect.ValueOf(nil), err
 		}
-		return traceMethod(objVal, path[1:], args)
+		// same reasoning as traceSetValue: keep objVal as reflect.Value rather than unwrapping it,
+		// so a method call two levels deep through a non-pointer struct field still reaches the
+		// original addressable receiver instead of a detached copy.
+		return traceMethod(objVal, path[1:], args, ctx)
 	default:
 		return reflect.ValueOf(nil), fmt.Errorf("no function path specified")
 	}
@@ -0,0 +1,78 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+type addressFact struct {
+	City string
+}
+
+func (a addressFact) Describe() string {
+	return "ships to " + a.City
+}
+
+type orderFact struct {
+	Shipping addressFact
+	Items    []int
+	Config   map[string]int
+}
+
+func TestDataContext_SetValue_NestedNonPointerField(t *testing.T) {
+	dataContext := NewDataContext()
+	order := &orderFact{Shipping: addressFact{City: "Jakarta"}}
+	if err := dataContext.Add("Order", order); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dataContext.SetValue("Order.Shipping.City", reflect.ValueOf("Bandung")); err != nil {
+		t.Fatal(err)
+	}
+	if order.Shipping.City != "Bandung" {
+		t.Fatalf("expected the real fact to be updated, got %q", order.Shipping.City)
+	}
+}
+
+func TestDataContext_ExecMethod_NestedNonPointerField(t *testing.T) {
+	dataContext := NewDataContext()
+	order := &orderFact{Shipping: addressFact{City: "Jakarta"}}
+	if err := dataContext.Add("Order", order); err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := dataContext.ExecMethod("Order.Shipping.Describe", []reflect.Value{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Interface().(string) != "ships to Jakarta" {
+		t.Fatalf("expected %q but got %v", "ships to Jakarta", ret.Interface())
+	}
+}
+
+func TestDataContext_SetValue_SliceIndexOutOfRange(t *testing.T) {
+	dataContext := NewDataContext()
+	order := &orderFact{Items: []int{1, 2, 3}}
+	if err := dataContext.Add("Order", order); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dataContext.SetValue("Order.Items[5]", reflect.ValueOf(9)); err == nil {
+		t.Fatal("expected an out-of-range error")
+	}
+}
+
+func TestDataContext_SetValue_MapKey(t *testing.T) {
+	dataContext := NewDataContext()
+	order := &orderFact{Config: map[string]int{}}
+	if err := dataContext.Add("Order", order); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dataContext.SetValue(`Order.Config["timeout"]`, reflect.ValueOf(30)); err != nil {
+		t.Fatal(err)
+	}
+	if order.Config["timeout"] != 30 {
+		t.Fatalf("expected 30 but got %d", order.Config["timeout"])
+	}
+}
@@ -0,0 +1,27 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+type scheduleFact struct {
+	Windows map[string]addressFact
+}
+
+func TestDataContext_SetValue_NestedFieldThroughMapValue(t *testing.T) {
+	dataContext := NewDataContext()
+	schedule := &scheduleFact{Windows: map[string]addressFact{
+		"morning": {City: "Jakarta"},
+	}}
+	if err := dataContext.Add("Schedule", schedule); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dataContext.SetValue(`Schedule.Windows["morning"].City`, reflect.ValueOf("Bandung")); err != nil {
+		t.Fatal(err)
+	}
+	if got := schedule.Windows["morning"].City; got != "Bandung" {
+		t.Fatalf("expected the map entry to be rebuilt with the new value, got %q", got)
+	}
+}
@@ -0,0 +1,217 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// pathSegmentKind distinguishes the three forms a path token can take: a plain `.field`
+// name, an `[intLiteral]` slice/array index, or a `["stringLiteral"]` map key.
+type pathSegmentKind int
+
+const (
+	segmentField pathSegmentKind = iota
+	segmentIndex
+	segmentKey
+)
+
+// pathSegment is a single step of a tokenized variable path, eg. the path `Users[0].Name`
+// tokenizes into [{field "Users"} {index 0} {field "Name"}].
+type pathSegment struct {
+	kind  pathSegmentKind
+	field string
+	index int
+	key   string
+}
+
+// tokenizePath splits a variable path such as `Users[0].Name` or `Config["timeout"]` into
+// pathSegments, recognising `.field`, `[intLiteral]` and `["stringLiteral"]` segments.
+func tokenizePath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+	i, n := 0, len(path)
+	for i < n {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := i + 1
+			for end < n && path[end] != ']' {
+				end++
+			}
+			if end >= n {
+				return nil, fmt.Errorf("unterminated bracket in path %q", path)
+			}
+			inner := path[i+1 : end]
+			i = end + 1
+			if len(inner) >= 2 && inner[0] == '"' && inner[len(inner)-1] == '"' {
+				segments = append(segments, pathSegment{kind: segmentKey, key: inner[1 : len(inner)-1]})
+			} else {
+				idx, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %q in path %q", inner, path)
+				}
+				segments = append(segments, pathSegment{kind: segmentIndex, index: idx})
+			}
+		default:
+			j := i
+			for j < n && path[j] != '.' && path[j] != '[' {
+				j++
+			}
+			segments = append(segments, pathSegment{kind: segmentField, field: path[i:j]})
+			i = j
+		}
+	}
+	return segments, nil
+}
+
+// rootKey returns the first segment's field name, which is used to look a fact up in
+// DataContext.ObjectStore. The root of a path is always a plain name.
+func rootKey(path []pathSegment) (string, error) {
+	if len(path) == 0 || path[0].kind != segmentField {
+		return "", fmt.Errorf("invalid path: must start with a fact name")
+	}
+	return path[0].field, nil
+}
+
+// reflectValueOf returns obj as a reflect.Value, unwrapping it if obj is already a reflect.Value
+// boxed in interface{} (the convention traceSetValue/traceMethod use to carry an addressable
+// intermediate down the recursion) instead of reflecting over the reflect.Value struct itself.
+func reflectValueOf(obj interface{}) reflect.Value {
+	if rv, ok := obj.(reflect.Value); ok {
+		return rv
+	}
+	return reflect.ValueOf(obj)
+}
+
+// collectionValue dereferences obj down to its underlying map, slice or array and indexes
+// it according to seg, returning an error instead of panicking on an out-of-range index or a
+// missing map key. The error is a plain fmt.Errorf value; there is no distinct error type to
+// match on.
+func collectionValue(obj interface{}, seg pathSegment) (reflect.Value, error) {
+	rv := reflectValueOf(obj)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	switch seg.kind {
+	case segmentIndex:
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			if seg.index < 0 || seg.index >= rv.Len() {
+				return reflect.Value{}, fmt.Errorf("index %d out of range, length is %d", seg.index, rv.Len())
+			}
+			return rv.Index(seg.index), nil
+		default:
+			return reflect.Value{}, fmt.Errorf("cannot index into %s with [%d]", rv.Kind().String(), seg.index)
+		}
+	case segmentKey:
+		if rv.Kind() != reflect.Map {
+			return reflect.Value{}, fmt.Errorf("cannot index into %s with key %q", rv.Kind().String(), seg.key)
+		}
+		keyVal, err := convertMapKey(seg.key, rv.Type().Key())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		val := rv.MapIndex(keyVal)
+		if !val.IsValid() {
+			return reflect.Value{}, fmt.Errorf("key %q not found in map", seg.key)
+		}
+		return val, nil
+	default:
+		return reflect.Value{}, fmt.Errorf("not an index or key path segment")
+	}
+}
+
+// setCollectionValue indexes obj the same way collectionValue does and stores newValue into
+// that slot. Map entries obtained via MapIndex are not addressable, so maps are updated
+// through SetMapIndex instead of Value.Set.
+func setCollectionValue(obj interface{}, seg pathSegment, newValue reflect.Value) error {
+	rv := reflectValueOf(obj)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	switch seg.kind {
+	case segmentIndex:
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			if seg.index < 0 || seg.index >= rv.Len() {
+				return fmt.Errorf("index %d out of range, length is %d", seg.index, rv.Len())
+			}
+			rv.Index(seg.index).Set(newValue)
+			return nil
+		default:
+			return fmt.Errorf("cannot index into %s with [%d]", rv.Kind().String(), seg.index)
+		}
+	case segmentKey:
+		if rv.Kind() != reflect.Map {
+			return fmt.Errorf("cannot index into %s with key %q", rv.Kind().String(), seg.key)
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		keyVal, err := convertMapKey(seg.key, rv.Type().Key())
+		if err != nil {
+			return err
+		}
+		rv.SetMapIndex(keyVal, newValue)
+		return nil
+	default:
+		return fmt.Errorf("not an index or key path segment")
+	}
+}
+
+// traceSetMapEntry writes through a map value when the path continues past the map key, eg.
+// `Config["timeout"].Seconds`. The value returned by MapIndex is unaddressable, so it is copied
+// out, the rest of the path is set on the addressable copy, and the copy is written back with
+// SetMapIndex; a pointer-typed map value is dereferenced and set in place instead, since the
+// pointed-to struct is addressable regardless of the map.
+func traceSetMapEntry(obj interface{}, seg pathSegment, rest []pathSegment, newValue reflect.Value) error {
+	rv := reflectValueOf(obj)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Map {
+		return fmt.Errorf("cannot index into %s with key %q", rv.Kind().String(), seg.key)
+	}
+
+	keyVal, err := convertMapKey(seg.key, rv.Type().Key())
+	if err != nil {
+		return err
+	}
+	entry := rv.MapIndex(keyVal)
+	if !entry.IsValid() {
+		return fmt.Errorf("key %q not found in map", seg.key)
+	}
+
+	if entry.Kind() == reflect.Ptr {
+		return traceSetValue(entry.Interface(), rest, newValue)
+	}
+
+	entryCopy := reflect.New(entry.Type()).Elem()
+	entryCopy.Set(entry)
+	if err := traceSetValue(entryCopy, rest, newValue); err != nil {
+		return err
+	}
+	rv.SetMapIndex(keyVal, entryCopy)
+	return nil
+}
+
+// convertMapKey parses a bracket string literal into the map's declared key type. Only
+// string and the built-in integer kinds are supported, which covers the keys rule scripts
+// realistically index maps with.
+func convertMapKey(literal string, keyType reflect.Type) (reflect.Value, error) {
+	switch keyType.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(literal).Convert(keyType), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(literal, 10, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("map key %q is not a valid %s", literal, keyType.Kind().String())
+		}
+		return reflect.ValueOf(n).Convert(keyType), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported map key type %s", keyType.String())
+	}
+}
@@ -0,0 +1,38 @@
+package ast
+
+import "testing"
+
+func TestDataContext_RetractFor_SurvivesFullCyclesBeforeClearing(t *testing.T) {
+	dataContext := NewDataContext()
+	dataContext.RetractFor("Fact", 2)
+
+	dataContext.Snapshot()
+	if !dataContext.IsRetracted("Fact") {
+		t.Fatal("expected Fact to still be retracted after 1 Snapshot call")
+	}
+
+	dataContext.Snapshot()
+	if !dataContext.IsRetracted("Fact") {
+		t.Fatal("expected Fact to still be retracted after 2 Snapshot calls")
+	}
+
+	dataContext.Snapshot()
+	if dataContext.IsRetracted("Fact") {
+		t.Fatal("expected Fact to be un-retracted after 3 Snapshot calls")
+	}
+}
+
+func TestDataContext_Restore_UndoesRetractionsFromAFailedPass(t *testing.T) {
+	dataContext := NewDataContext()
+	snapshot := dataContext.Snapshot()
+
+	dataContext.RetractFor("Fact", 1)
+	if !dataContext.IsRetracted("Fact") {
+		t.Fatal("expected Fact to be retracted immediately after RetractFor")
+	}
+
+	dataContext.Restore(snapshot)
+	if dataContext.IsRetracted("Fact") {
+		t.Fatal("expected Restore to undo the retraction made after the snapshot was taken")
+	}
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hyperjumptech/grule-rule-engine/ast"
+)
+
+// DefaultMaxCycle is used when GruleEngine.MaxCycle is left at its zero value.
+const DefaultMaxCycle = 5000
+
+// RuleEntry is a single rule the engine can evaluate: When reports whether the rule should
+// fire, Then is its action block. Salience picks evaluation order within a cycle, highest first.
+type RuleEntry struct {
+	Name     string
+	Salience int
+	When     func(dataCtx ast.IDataContext) (bool, error)
+	Then     func(dataCtx ast.IDataContext) error
+}
+
+// GruleEngine runs a rule cycle against a DataContext until a pass records no variable change
+// (see IDataContext.HasVariableChange), the DataContext is marked complete, or MaxCycle is
+// exceeded. A pass in which rules match but change no variable also ends the run.
+type GruleEngine struct {
+	MaxCycle uint64
+}
+
+// Execute repeatedly evaluates rules against dataCtx. Between every rule evaluation it checks
+// dataCtx.Context() for cancellation or a deadline and aborts the cycle cleanly if it has been
+// cancelled. Each pass is checkpointed with dataCtx.Snapshot so a failed pass can be rolled back
+// with dataCtx.Restore, and the currently firing rule is recorded via dataCtx.SetCurrentRule so
+// any changes it makes carry provenance in the change journal.
+func (g *GruleEngine) Execute(dataCtx ast.IDataContext, rules []*RuleEntry) error {
+	maxCycle := g.MaxCycle
+	if maxCycle == 0 {
+		maxCycle = DefaultMaxCycle
+	}
+
+	sorted := make([]*RuleEntry, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Salience > sorted[j].Salience
+	})
+
+	for cycle := uint64(1); cycle <= maxCycle; cycle++ {
+		if err := dataCtx.Context().Err(); err != nil {
+			return err
+		}
+
+		snapshot := dataCtx.Snapshot()
+		dataCtx.ResetVariableChangeCount()
+
+		if err := g.runPass(dataCtx, sorted); err != nil {
+			dataCtx.Restore(snapshot)
+			return err
+		}
+
+		if dataCtx.IsComplete() {
+			return nil
+		}
+		if !dataCtx.HasVariableChange() {
+			return nil
+		}
+	}
+	return fmt.Errorf("rule engine exceeded max cycle of %d", maxCycle)
+}
+
+func (g *GruleEngine) runPass(dataCtx ast.IDataContext, rules []*RuleEntry) error {
+	for _, rule := range rules {
+		if dataCtx.IsComplete() {
+			return nil
+		}
+		if err := dataCtx.Context().Err(); err != nil {
+			return err
+		}
+
+		matched, err := rule.When(dataCtx)
+		if err != nil {
+			return fmt.Errorf("error evaluating condition of rule %s: %w", rule.Name, err)
+		}
+		if !matched {
+			continue
+		}
+
+		dataCtx.SetCurrentRule(rule.Name)
+		if err := rule.Then(dataCtx); err != nil {
+			return fmt.Errorf("error executing rule %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hyperjumptech/grule-rule-engine/ast"
+)
+
+type counterFact struct {
+	Value int
+}
+
+func TestGruleEngine_Execute_StopsWhenNoChange(t *testing.T) {
+	dataContext := ast.NewDataContext()
+	fact := &counterFact{Value: 0}
+	if err := dataContext.Add("Counter", fact); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []*RuleEntry{
+		{
+			Name: "IncrementUntilFive",
+			When: func(dataCtx ast.IDataContext) (bool, error) {
+				return fact.Value < 5, nil
+			},
+			Then: func(dataCtx ast.IDataContext) error {
+				fact.Value++
+				return dataCtx.SetValue("Counter.Value", reflect.ValueOf(fact.Value))
+			},
+		},
+	}
+
+	engine := &GruleEngine{MaxCycle: 10}
+	if err := engine.Execute(dataContext, rules); err != nil {
+		t.Fatal(err)
+	}
+	if fact.Value != 5 {
+		t.Fatalf("expected counter to reach 5 but got %d", fact.Value)
+	}
+}
+
+func TestGruleEngine_Execute_AbortsOnContextCancellation(t *testing.T) {
+	dataContext := ast.NewDataContext()
+	fact := &counterFact{Value: 0}
+	if err := dataContext.Add("Counter", fact); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dataContext = dataContext.WithContext(ctx)
+
+	rules := []*RuleEntry{
+		{
+			Name: "IncrementForever",
+			When: func(dataCtx ast.IDataContext) (bool, error) {
+				return true, nil
+			},
+			Then: func(dataCtx ast.IDataContext) error {
+				fact.Value++
+				if fact.Value == 3 {
+					cancel()
+				}
+				return dataCtx.SetValue("Counter.Value", reflect.ValueOf(fact.Value))
+			},
+		},
+	}
+
+	engine := &GruleEngine{MaxCycle: 1000}
+	err := engine.Execute(dataContext, rules)
+	if err == nil {
+		t.Fatal("expected Execute to abort with the cancellation error")
+	}
+	if fact.Value > 4 {
+		t.Fatalf("expected engine to stop shortly after cancellation, but counter reached %d", fact.Value)
+	}
+}